@@ -0,0 +1,500 @@
+package dalec
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+// SourceGenerator configures a generator that runs against a source before
+// the main build, producing patches or other preprocessing artifacts.
+type SourceGenerator struct {
+	// Subpath is the path, relative to the source root, that the generator
+	// should operate on.
+	Subpath string `yaml:"subpath,omitempty" json:"subpath,omitempty"`
+
+	// Gomod configures go module preprocessing for this source.
+	Gomod *GeneratorGomod `yaml:"gomod,omitempty" json:"gomod,omitempty"`
+
+	// GoWork configures go.work workspace preprocessing for this source.
+	GoWork *GeneratorGoWork `yaml:"goWork,omitempty" json:"goWork,omitempty"`
+}
+
+// Validate checks that the generator's configuration is well-formed.
+func (g *SourceGenerator) Validate() error {
+	if g == nil {
+		return nil
+	}
+	if err := g.Gomod.Validate(); err != nil {
+		return err
+	}
+	return g.GoWork.Validate()
+}
+
+const (
+	// gomodSSHAgentSocketID/Path identify the single forwarded SSH agent
+	// socket shared by every host configured with GomodAuth.SSHAgent.
+	// Unlike known_hosts, which must be pinned per host, the agent itself
+	// is just whichever local ssh-agent the frontend was invoked with, so
+	// there's nothing to gain from forwarding it once per host.
+	gomodSSHAgentSocketID   = "gomod-ssh-agent"
+	gomodSSHAgentSocketPath = "/run/gomod-ssh-agent.sock"
+)
+
+// withGomodSecretsAndSockets returns the llb.RunOptions needed to expose
+// secrets or sockets required by this generator's auth configuration: the
+// forwarded SSH agent socket, if any host is configured with
+// GomodAuth.SSHAgent.
+func (g *SourceGenerator) withGomodSecretsAndSockets() []llb.RunOption {
+	if g == nil || g.Gomod == nil || !g.Gomod.usesSSHAgent() {
+		return nil
+	}
+
+	return []llb.RunOption{llb.AddSSHSocket(
+		llb.SSHID(gomodSSHAgentSocketID),
+		llb.SSHSocketTarget(gomodSSHAgentSocketPath),
+	)}
+}
+
+// usesSSHAgent reports whether any host configured for this generator
+// forwards the SSH agent rather than using a static key.
+func (g *GeneratorGomod) usesSSHAgent() bool {
+	if g == nil {
+		return false
+	}
+	for _, auth := range g.Auth {
+		if auth.SSHAgent {
+			return true
+		}
+	}
+	return false
+}
+
+// GeneratorGomod configures generation of a patch that applies `go mod edit`
+// (and optionally `go mod tidy`) directives to one or more Go modules found
+// in a source.
+type GeneratorGomod struct {
+	// Paths is the list of module directories (relative to Subpath) to edit.
+	// Defaults to the module at Subpath itself.
+	Paths []string `yaml:"paths,omitempty" json:"paths,omitempty"`
+
+	// Auth configures credentials for private module hosts, keyed by host.
+	Auth map[string]GomodAuth `yaml:"auth,omitempty" json:"auth,omitempty"`
+
+	// Edits lists the `go mod edit` directives to apply before diffing.
+	Edits *GomodEdits `yaml:"edits,omitempty" json:"edits,omitempty"`
+
+	// Proxy configures GOPROXY-aware preflight checks and offline builds.
+	Proxy *GomodProxy `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+}
+
+// Validate checks that all edit directives and auth configurations are
+// well-formed.
+func (g *GeneratorGomod) Validate() error {
+	if g == nil {
+		return nil
+	}
+	for _, host := range SortMapKeys(g.Auth) {
+		auth := g.Auth[host]
+		if err := auth.Validate(); err != nil {
+			return errors.Wrapf(err, "auth for host %q", host)
+		}
+	}
+	return g.Edits.Validate()
+}
+
+// GomodAuth configures credentials used to fetch a private Go module host.
+type GomodAuth struct {
+	Token  string        `yaml:"token,omitempty" json:"token,omitempty"`
+	Header string        `yaml:"header,omitempty" json:"header,omitempty"`
+	SSH    *GomodSSHAuth `yaml:"ssh,omitempty" json:"ssh,omitempty"`
+
+	// SSHAgent forwards the frontend's SSH_AUTH_SOCK for use against this
+	// host via llb.AddSSHSocket, instead of relying on a static
+	// SSH.PrivateKey. The forwarded socket is shared by every host that
+	// sets SSHAgent.
+	SSHAgent bool `yaml:"sshAgent,omitempty" json:"sshAgent,omitempty"`
+
+	// KnownHosts pins this host's key(s) inline, in known_hosts format.
+	// Mutually exclusive with KnownHostsSource.
+	KnownHosts string `yaml:"knownHosts,omitempty" json:"knownHosts,omitempty"`
+
+	// KnownHostsSource names a Source containing a known_hosts file to pin
+	// this host's key(s) from. Mutually exclusive with KnownHosts.
+	KnownHostsSource string `yaml:"knownHostsSource,omitempty" json:"knownHostsSource,omitempty"`
+}
+
+// Validate rejects configurations that are ambiguous about which SSH
+// credential to use for a host, or that can't actually take effect.
+func (a *GomodAuth) Validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.SSHAgent && a.SSH == nil {
+		return errors.New("SSHAgent requires SSH to be set, to identify the host's ssh:// insteadOf rewrite")
+	}
+	if a.SSHAgent && a.SSH != nil && a.SSH.PrivateKey != "" {
+		return errors.New("cannot set both SSHAgent and a static SSH private key for the same host")
+	}
+	if a.KnownHosts != "" && a.KnownHostsSource != "" {
+		return errors.New("cannot set both KnownHosts and KnownHostsSource for the same host")
+	}
+	if a.SSH != nil && !a.SSH.Insecure && a.KnownHosts == "" && a.KnownHostsSource == "" {
+		return errors.New("SSH requires KnownHosts or KnownHostsSource to be set unless SSH.Insecure is set")
+	}
+	return nil
+}
+
+// GomodSSHAuth configures SSH-based access to a private Go module host.
+type GomodSSHAuth struct {
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	// PrivateKey is a static, inline SSH private key used to authenticate
+	// to this host. Mutually exclusive with GomodAuth.SSHAgent.
+	PrivateKey string `yaml:"privateKey,omitempty" json:"privateKey,omitempty"`
+	// Insecure disables host key verification for this host. Prefer pinning
+	// KnownHosts on GomodAuth instead of setting this. All hosts share one
+	// GIT_SSH_COMMAND, so verification is disabled only when every SSH host
+	// sets Insecure; if any SSH host is secured, strict host key checking
+	// applies to every host, including this one.
+	Insecure bool `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+}
+
+// GomodEdits lists the `go mod edit` (and optional `go mod tidy`) directives
+// to apply to a module before capturing a diff of go.mod/go.sum.
+type GomodEdits struct {
+	Require []GomodRequire `yaml:"require,omitempty" json:"require,omitempty"`
+	Replace []GomodReplace `yaml:"replace,omitempty" json:"replace,omitempty"`
+
+	// Exclude maps to one or more `go mod edit -exclude` directives.
+	Exclude []GomodExclude `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	// Retract maps to one or more `go mod edit -retract` directives.
+	Retract []GomodRetract `yaml:"retract,omitempty" json:"retract,omitempty"`
+	// GoDebug maps to one or more `go mod edit -godebug` directives.
+	GoDebug []GomodGoDebug `yaml:"godebug,omitempty" json:"godebug,omitempty"`
+	// Toolchain sets the module's toolchain directive via `go mod edit -toolchain`.
+	Toolchain string `yaml:"toolchain,omitempty" json:"toolchain,omitempty"`
+	// Go sets the module's go directive via `go mod edit -go`.
+	Go string `yaml:"go,omitempty" json:"go,omitempty"`
+
+	// Tidy, when set, runs `go mod tidy` after the edits above so that any
+	// pruned or newly-required dependencies are captured in the generated
+	// patch alongside the explicit edits.
+	Tidy bool `yaml:"tidy,omitempty" json:"tidy,omitempty"`
+}
+
+// Validate checks that all directives in the edit set are well-formed.
+func (e *GomodEdits) Validate() error {
+	if e == nil {
+		return nil
+	}
+	for _, r := range e.Require {
+		if _, err := r.goModEditArg(); err != nil {
+			return errors.Wrap(err, "invalid require")
+		}
+	}
+	for _, r := range e.Replace {
+		if _, err := r.goModEditArg(); err != nil {
+			return errors.Wrap(err, "invalid replace")
+		}
+	}
+	for _, x := range e.Exclude {
+		if _, err := x.goModEditArg(); err != nil {
+			return errors.Wrap(err, "invalid exclude")
+		}
+	}
+	for _, r := range e.Retract {
+		if _, err := r.goModEditArg(); err != nil {
+			return errors.Wrap(err, "invalid retract")
+		}
+	}
+	for _, d := range e.GoDebug {
+		if _, err := d.goModEditArg(); err != nil {
+			return errors.Wrap(err, "invalid godebug")
+		}
+	}
+	return nil
+}
+
+// GomodRequire represents a `go mod edit -require=module@version` directive.
+type GomodRequire struct {
+	Module  string `yaml:"module" json:"module"`
+	Version string `yaml:"version" json:"version"`
+}
+
+func (r *GomodRequire) goModEditArg() (string, error) {
+	if r.Module == "" {
+		return "", errors.New("module must be non-empty")
+	}
+	if !strings.Contains(r.Version, "@") {
+		return "", errors.New("version must include @version")
+	}
+	return r.Version, nil
+}
+
+func (r *GomodRequire) fromString(s string) error {
+	mod, ver, ok := strings.Cut(s, ":")
+	if !ok {
+		return errors.New("must be in the form module:module@version")
+	}
+	r.Module, r.Version = mod, ver
+	_, err := r.goModEditArg()
+	return err
+}
+
+func (r *GomodRequire) UnmarshalYAML(data []byte) error {
+	var s string
+	if err := yaml.Unmarshal(data, &s); err == nil {
+		return r.fromString(s)
+	}
+	type plain GomodRequire
+	var p plain
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = GomodRequire(p)
+	_, err := r.goModEditArg()
+	return err
+}
+
+func (r *GomodRequire) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return r.fromString(s)
+	}
+	type plain GomodRequire
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = GomodRequire(p)
+	_, err := r.goModEditArg()
+	return err
+}
+
+// GomodReplace represents a `go mod edit -replace=old=new` directive.
+type GomodReplace struct {
+	Original string `yaml:"old" json:"old"`
+	Update   string `yaml:"new" json:"new"`
+}
+
+func (r *GomodReplace) goModEditArg() (string, error) {
+	if r.Original == "" {
+		return "", errors.New("old must be non-empty")
+	}
+	if r.Update == "" {
+		return "", errors.New("new must be non-empty")
+	}
+	return r.Original + "=" + r.Update, nil
+}
+
+func (r *GomodReplace) fromString(s string) error {
+	old, new, ok := strings.Cut(s, ":")
+	if !ok {
+		return errors.New("must be in the form old:new")
+	}
+	r.Original, r.Update = old, new
+	_, err := r.goModEditArg()
+	return err
+}
+
+func (r *GomodReplace) UnmarshalYAML(data []byte) error {
+	var s string
+	if err := yaml.Unmarshal(data, &s); err == nil {
+		return r.fromString(s)
+	}
+	type plain GomodReplace
+	var p plain
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = GomodReplace(p)
+	_, err := r.goModEditArg()
+	return err
+}
+
+func (r *GomodReplace) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return r.fromString(s)
+	}
+	type plain GomodReplace
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = GomodReplace(p)
+	_, err := r.goModEditArg()
+	return err
+}
+
+// GomodExclude represents a `go mod edit -exclude=module@version` directive.
+type GomodExclude struct {
+	Module  string `yaml:"module" json:"module"`
+	Version string `yaml:"version" json:"version"`
+}
+
+func (x *GomodExclude) goModEditArg() (string, error) {
+	if x.Module == "" {
+		return "", errors.New("module must be non-empty")
+	}
+	if x.Version == "" {
+		return "", errors.New("version must be non-empty")
+	}
+	return x.Module + "@" + x.Version, nil
+}
+
+func (x *GomodExclude) fromString(s string) error {
+	mod, ver, ok := strings.Cut(s, "@")
+	if !ok {
+		return errors.New("must be in the form module@version")
+	}
+	x.Module, x.Version = mod, ver
+	_, err := x.goModEditArg()
+	return err
+}
+
+func (x *GomodExclude) UnmarshalYAML(data []byte) error {
+	var s string
+	if err := yaml.Unmarshal(data, &s); err == nil {
+		return x.fromString(s)
+	}
+	type plain GomodExclude
+	var p plain
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*x = GomodExclude(p)
+	_, err := x.goModEditArg()
+	return err
+}
+
+func (x *GomodExclude) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return x.fromString(s)
+	}
+	type plain GomodExclude
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*x = GomodExclude(p)
+	_, err := x.goModEditArg()
+	return err
+}
+
+// GomodRetract represents a `go mod edit -retract=version` (or
+// `-retract=[low,high]` range) directive.
+type GomodRetract struct {
+	Low  string `yaml:"low" json:"low"`
+	High string `yaml:"high" json:"high"`
+}
+
+func (r *GomodRetract) goModEditArg() (string, error) {
+	if r.Low == "" {
+		return "", errors.New("version must be non-empty")
+	}
+	if r.High == "" || r.High == r.Low {
+		return r.Low, nil
+	}
+	return "[" + r.Low + "," + r.High + "]", nil
+}
+
+func (r *GomodRetract) fromString(s string) error {
+	if low, high, ok := strings.Cut(s, ","); ok {
+		r.Low, r.High = low, high
+	} else {
+		r.Low, r.High = s, s
+	}
+	_, err := r.goModEditArg()
+	return err
+}
+
+func (r *GomodRetract) UnmarshalYAML(data []byte) error {
+	var s string
+	if err := yaml.Unmarshal(data, &s); err == nil {
+		return r.fromString(s)
+	}
+	type plain GomodRetract
+	var p plain
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = GomodRetract(p)
+	_, err := r.goModEditArg()
+	return err
+}
+
+func (r *GomodRetract) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return r.fromString(s)
+	}
+	type plain GomodRetract
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = GomodRetract(p)
+	_, err := r.goModEditArg()
+	return err
+}
+
+// GomodGoDebug represents a `go mod edit -godebug=key=value` directive.
+type GomodGoDebug struct {
+	Key   string `yaml:"key" json:"key"`
+	Value string `yaml:"value" json:"value"`
+}
+
+func (d *GomodGoDebug) goModEditArg() (string, error) {
+	if d.Key == "" {
+		return "", errors.New("key must be non-empty")
+	}
+	if d.Value == "" {
+		return "", errors.New("value must be non-empty")
+	}
+	return d.Key + "=" + d.Value, nil
+}
+
+func (d *GomodGoDebug) fromString(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return errors.New("must be in the form key=value")
+	}
+	d.Key, d.Value = k, v
+	_, err := d.goModEditArg()
+	return err
+}
+
+func (d *GomodGoDebug) UnmarshalYAML(data []byte) error {
+	var s string
+	if err := yaml.Unmarshal(data, &s); err == nil {
+		return d.fromString(s)
+	}
+	type plain GomodGoDebug
+	var p plain
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*d = GomodGoDebug(p)
+	_, err := d.goModEditArg()
+	return err
+}
+
+func (d *GomodGoDebug) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return d.fromString(s)
+	}
+	type plain GomodGoDebug
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*d = GomodGoDebug(p)
+	_, err := d.goModEditArg()
+	return err
+}
@@ -0,0 +1,105 @@
+package dalec
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// GeneratorGoWork configures generation of a patch for a `go.work` based
+// multi-module workspace. Unlike GeneratorGomod, which edits a single
+// module's go.mod in isolation, GeneratorGoWork lets a workspace-level
+// go.work be created/edited so that `replace` directives apply consistently
+// across every module it `use`s.
+type GeneratorGoWork struct {
+	// Use lists the module directories (relative to the source root) that
+	// should be added to the workspace via `go work use`.
+	Use []string `yaml:"use,omitempty" json:"use,omitempty"`
+
+	// Replace lists workspace-level replace directives applied via
+	// `go work edit -replace`.
+	Replace []GomodReplace `yaml:"replace,omitempty" json:"replace,omitempty"`
+
+	// Go sets the workspace's go directive via `go work edit -go`.
+	Go string `yaml:"go,omitempty" json:"go,omitempty"`
+}
+
+// Validate checks that the workspace directives are well-formed.
+func (g *GeneratorGoWork) Validate() error {
+	if g == nil {
+		return nil
+	}
+	if len(g.Use) == 0 {
+		return errors.New("use must list at least one module")
+	}
+	for _, r := range g.Replace {
+		if _, err := r.goModEditArg(); err != nil {
+			return errors.Wrap(err, "invalid replace")
+		}
+	}
+	return nil
+}
+
+// goWorkEditArgs builds the `go work edit` flags for the workspace's
+// replace directives and go directive. `use` entries are applied separately
+// via `go work use`, since `go work edit` has no -use flag.
+func (g *GeneratorGoWork) goWorkEditArgs() ([]string, error) {
+	if g == nil {
+		return nil, nil
+	}
+
+	var args []string
+	for _, r := range g.Replace {
+		arg, err := r.goModEditArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-replace="+arg)
+	}
+
+	if g.Go != "" {
+		args = append(args, "-go="+g.Go)
+	}
+
+	return args, nil
+}
+
+// validateGeneratorWorkspace rejects a source whose Gomod.Paths overlap with
+// another generator's GoWork.Use entries, since a module can't be edited
+// both standalone and as part of the workspace it belongs to. GoWork.Use is
+// relative to the source root, while Gomod.Paths is relative to that
+// generator's own Subpath, so both are normalized to source-root-relative,
+// cleaned paths before comparing.
+func validateGeneratorWorkspace(gens []*SourceGenerator) error {
+	used := make(map[string]bool)
+	for _, gen := range gens {
+		if gen == nil || gen.GoWork == nil {
+			continue
+		}
+		for _, path := range gen.GoWork.Use {
+			used[filepath.Clean(path)] = true
+		}
+	}
+
+	if len(used) == 0 {
+		return nil
+	}
+
+	for _, gen := range gens {
+		if gen == nil || gen.Gomod == nil {
+			continue
+		}
+		paths := gen.Gomod.Paths
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		for _, path := range paths {
+			relToRoot := filepath.Clean(filepath.Join(gen.Subpath, path))
+			if used[relToRoot] {
+				return errors.Errorf("gomod path %q conflicts with a go.work use entry", path)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package dalec
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GomodDiffBackend selects how generateGomodPatchStateForSource computes the
+// go.mod/go.sum diff after running `go mod edit`/`go mod tidy`.
+type GomodDiffBackend string
+
+const (
+	// GomodDiffBackendShell (the default) shells out to `git diff` inside
+	// the buildkit worker image.
+	GomodDiffBackendShell GomodDiffBackend = "shell"
+
+	// GomodDiffBackendGoGit computes the diff in-process using go-git's
+	// line-diff helper, so the worker image needs no `git` or `diff`. The
+	// auth setup and `go mod edit`/`go mod tidy` commands still run under
+	// `/bin/sh` in the worker image. Computing the diff in-process makes
+	// patch output deterministic across worker distros and lets tests
+	// exercise the diff path without buildkit.
+	GomodDiffBackendGoGit GomodDiffBackend = "gogit"
+)
+
+// errStateFileNotFound is returned by readStateFile for a missing file, as
+// opposed to a real solve/read failure, so callers can tell "go.mod didn't
+// exist before/after the edit" apart from "the gateway call failed".
+var errStateFileNotFound = stderrors.New("state file not found")
+
+// generateGomodPatchStateGoGit mirrors generateGomodPatchStateForSource's
+// shell backend, but instead of running `git diff` inside the container it
+// solves the pre-edit and post-edit module trees separately, reads
+// go.mod/go.sum back via the gateway client, and builds the unified diff
+// in-process with go-git's line-diff machinery.
+func (s *Spec) generateGomodPatchStateGoGit(ctx context.Context, client gwclient.Client, sOpt SourceOpts, sourceName string, gen *SourceGenerator, editCmd string, paths []string, baseState llb.State, worker llb.State, credHelper llb.RunOption, opts ...llb.ConstraintsOpt) (*llb.State, error) {
+	if client == nil {
+		return nil, errors.New("gomod diff backend \"gogit\" requires a gateway client")
+	}
+
+	const (
+		workDir   = "/work/src"
+		proxyPath = "/go/pkg/mod" // Standard Go module cache path
+	)
+	joinedWorkDir := filepath.Join(workDir, sourceName, gen.Subpath)
+	modules := computeModuleInfos(joinedWorkDir, gen.Subpath, paths)
+
+	// Resolve any KnownHostsSource references to mounts, same as the shell
+	// backend; their contents are cat'd into the combined known_hosts file
+	// by the rendered auth setup script.
+	var knownHostsSourceMounts []llb.RunOption
+	var knownHostsSourcePaths []string
+	for _, host := range SortMapKeys(gen.Gomod.Auth) {
+		auth := gen.Gomod.Auth[host]
+		if auth.KnownHostsSource == "" {
+			continue
+		}
+		srcState, ok := s.getPatchedSources(sOpt, worker, func(name string) bool {
+			return name == auth.KnownHostsSource
+		}, opts...)[auth.KnownHostsSource]
+		if !ok {
+			continue
+		}
+		mountDir := "/tmp/gomod_known_hosts_src/" + host
+		knownHostsSourceMounts = append(knownHostsSourceMounts, llb.AddMount(mountDir, srcState))
+		knownHostsSourcePaths = append(knownHostsSourcePaths, filepath.Join(mountDir, "known_hosts"))
+	}
+
+	auth, err := buildGomodAuthSetup(gen, knownHostsSourcePaths)
+	if err != nil {
+		return nil, err
+	}
+	authSetup, err := renderGomodAuthSetup(auth, knownHostsSourcePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []string
+	for _, mod := range modules {
+		cmds = append(cmds, fmt.Sprintf("(cd %s && %s)", mod.ModuleDir, editCmd))
+	}
+	script := authSetup + "\n" + strings.Join(cmds, " && ") + "\n"
+
+	moduleCacheMount := llb.AddMount(proxyPath, llb.Scratch(), llb.AsPersistentCacheDir(GomodCacheKey, llb.CacheMountShared))
+	if proxy := gen.Gomod.Proxy; proxy != nil && proxy.Offline && proxy.OfflineCache != "" {
+		if cacheState, ok := s.getPatchedSources(sOpt, worker, func(name string) bool {
+			return name == proxy.OfflineCache
+		}, opts...)[proxy.OfflineCache]; ok {
+			moduleCacheMount = llb.AddMount(proxyPath, cacheState)
+		}
+	}
+
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", script}),
+		llb.AddMount(workDir, baseState),
+		moduleCacheMount,
+		llb.AddEnv("GOPATH", "/go"),
+		llb.AddEnv("TMP_GOMODCACHE", proxyPath),
+		WithConstraints(opts...),
+		ProgressGroup("Generate gomod patch (go-git) for source: " + sourceName),
+	}
+	runOpts = append(runOpts, knownHostsSourceMounts...)
+	runOpts = append(runOpts, gen.Gomod.Proxy.runOpts()...)
+	if credHelper != nil {
+		runOpts = append(runOpts, credHelper)
+	}
+	runOpts = append(runOpts, gen.withGomodSecretsAndSockets()...)
+
+	afterState := worker.Run(runOpts...).AddMount(workDir, baseState)
+
+	var buf bytes.Buffer
+	for _, mod := range modules {
+		// baseState/afterState are solved as the whole source root, so a
+		// module's go.mod/go.sum live under <sourceName>/<relModulePath>,
+		// not at RelGoModPath/RelGoSumPath alone (those are relative to the
+		// source root, without the sourceName prefix the shell backend's
+		// workDir mount adds).
+		for _, relPath := range []string{mod.RelGoModPath, mod.RelGoSumPath} {
+			srcRelPath := filepath.ToSlash(filepath.Join(sourceName, relPath))
+
+			before, err := readStateFile(ctx, client, baseState, srcRelPath)
+			if err != nil {
+				if !stderrors.Is(err, errStateFileNotFound) {
+					return nil, errors.Wrapf(err, "failed to read %s before edit", srcRelPath)
+				}
+				before = nil
+			}
+			after, err := readStateFile(ctx, client, afterState, srcRelPath)
+			if err != nil {
+				if !stderrors.Is(err, errStateFileNotFound) {
+					return nil, errors.Wrapf(err, "failed to read %s after edit", srcRelPath)
+				}
+				after = nil
+			}
+
+			if bytes.Equal(before, after) {
+				continue
+			}
+
+			if err := writeUnifiedDiff(&buf, relPath, before, after); err != nil {
+				return nil, errors.Wrapf(err, "failed to diff %s", relPath)
+			}
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+
+	patchSourceName := fmt.Sprintf(gomodPatchSourcePrefix+"%s", sourceName)
+	finalPatchPath := filepath.Join("/", patchSourceName, gomodPatchFilename)
+
+	patchSt := llb.Scratch().
+		File(llb.Mkdir(filepath.Join("/", patchSourceName), 0755, llb.WithParents(true)), WithConstraints(opts...)).
+		File(llb.Mkfile(finalPatchPath, 0644, buf.Bytes()), WithConstraints(opts...))
+
+	return &patchSt, nil
+}
+
+// readStateFile solves st and reads a single file out of the resulting ref.
+// A missing file returns errStateFileNotFound; any other failure (a solve
+// error, a transport error, etc.) is returned as-is so callers don't mistake
+// it for an absent file.
+func readStateFile(ctx context.Context, client gwclient.Client, st llb.State, relPath string) ([]byte, error) {
+	def, err := st.Marshal(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal state")
+	}
+
+	res, err := client.Solve(ctx, gwclient.SolveRequest{Definition: def.ToPB()})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to solve state")
+	}
+
+	ref, err := res.SingleRef()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ref.ReadFile(ctx, gwclient.ReadRequest{Filename: relPath})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errStateFileNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeUnifiedDiff writes a `strip=1`-compatible unified diff hunk for a
+// single file's before/after contents, diffing at line granularity via
+// go-git's diff.Do (which wraps sergi/go-diff's diffmatchpatch with a
+// DiffLinesToChars/DiffCharsToLines pass) so that a change within a line
+// doesn't fragment into a partial-line insert/delete pair.
+func writeUnifiedDiff(w *bytes.Buffer, relPath string, before, after []byte) error {
+	oldName, newName := "a/"+relPath, "b/"+relPath
+	if before == nil {
+		oldName = "/dev/null"
+	}
+	if after == nil {
+		newName = "/dev/null"
+	}
+
+	fmt.Fprintf(w, "diff --git a/%s b/%s\n", relPath, relPath)
+	switch {
+	case before == nil:
+		fmt.Fprintln(w, "new file mode 100644")
+	case after == nil:
+		fmt.Fprintln(w, "deleted file mode 100644")
+	}
+	fmt.Fprintf(w, "--- %s\n", oldName)
+	fmt.Fprintf(w, "+++ %s\n", newName)
+
+	oldLines := splitLines(before)
+	newLines := splitLines(after)
+	oldEndsWithNewline := len(before) == 0 || bytes.HasSuffix(before, []byte("\n"))
+	newEndsWithNewline := len(after) == 0 || bytes.HasSuffix(after, []byte("\n"))
+
+	// A hunk with no old (resp. new) lines starts at line 0, per the
+	// unified diff convention `git diff` itself uses for file
+	// creation/deletion (e.g. `@@ -0,0 +1,N @@`).
+	oldStart, newStart := 1, 1
+	if len(oldLines) == 0 {
+		oldStart = 0
+	}
+	if len(newLines) == 0 {
+		newStart = 0
+	}
+	fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", oldStart, len(oldLines), newStart, len(newLines))
+
+	diffs := diff.Do(string(before), string(after))
+
+	var oldSeen, newSeen int
+	writeLine := func(prefix, line string, lastOld, lastNew bool) {
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+		if lastOld && !oldEndsWithNewline {
+			fmt.Fprintln(w, `\ No newline at end of file`)
+		} else if lastNew && !newEndsWithNewline {
+			fmt.Fprintln(w, `\ No newline at end of file`)
+		}
+	}
+
+	for _, d := range diffs {
+		for _, line := range splitLines([]byte(d.Text)) {
+			switch d.Type {
+			case diffmatchpatch.DiffDelete:
+				oldSeen++
+				writeLine("-", line, oldSeen == len(oldLines), false)
+			case diffmatchpatch.DiffInsert:
+				newSeen++
+				writeLine("+", line, false, newSeen == len(newLines))
+			default:
+				oldSeen++
+				newSeen++
+				writeLine(" ", line, oldSeen == len(oldLines), newSeen == len(newLines))
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitLines splits b into lines without their trailing "\n", matching how
+// `git diff`/`patch` count lines for a hunk header: a file with N newlines
+// and no trailing newline still has N+1 lines.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.Split(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
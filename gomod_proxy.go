@@ -0,0 +1,222 @@
+package dalec
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// gomodProxyPreflightTimeout bounds how long the frontend-side preflight
+// waits on the module proxy before giving up, so an unreachable/hanging
+// proxy fails the build instead of blocking it indefinitely.
+const gomodProxyPreflightTimeout = 30 * time.Second
+
+// GomodProxy configures GOPROXY-aware preflight checks and offline builds
+// for a gomod generator.
+type GomodProxy struct {
+	// URL is the module proxy to preflight `require` versions against and
+	// to set as GOPROXY for the edit/tidy run. If empty, no preflight is
+	// performed and GOPROXY is left at its default.
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+
+	// Sumdb sets GOSUMDB for the edit/tidy run. Set to "off" to disable sum
+	// database verification entirely.
+	Sumdb string `yaml:"sumdb,omitempty" json:"sumdb,omitempty"`
+
+	// Direct, when set, appends ",direct" to GOPROXY so modules not found
+	// on URL fall back to being fetched directly from their VCS.
+	Direct bool `yaml:"direct,omitempty" json:"direct,omitempty"`
+
+	// Offline, when set, mounts OfflineCache at the module cache path
+	// instead of an empty scratch cache dir, and runs edits with
+	// `-mod=readonly` instead of the default `-mod=mod`.
+	Offline bool `yaml:"offline,omitempty" json:"offline,omitempty"`
+
+	// OfflineCache names a Source, pre-populated with GOPATH/pkg/mod
+	// contents, to mount at the module cache path when Offline is set.
+	OfflineCache string `yaml:"offlineCache,omitempty" json:"offlineCache,omitempty"`
+}
+
+// runOpts returns the llb.RunOptions needed to apply this proxy
+// configuration to a `go mod edit`/`go mod tidy` run.
+func (p *GomodProxy) runOpts() []llb.RunOption {
+	if p == nil {
+		return nil
+	}
+
+	var opts []llb.RunOption
+	if p.URL != "" {
+		proxy := p.URL
+		if p.Direct {
+			proxy += ",direct"
+		}
+		opts = append(opts, llb.AddEnv("GOPROXY", proxy))
+	}
+	if p.Sumdb != "" {
+		opts = append(opts, llb.AddEnv("GOSUMDB", p.Sumdb))
+		if p.Sumdb == "off" {
+			opts = append(opts, llb.AddEnv("GONOSUMCHECK", "1"))
+		}
+	}
+	if p.Offline {
+		opts = append(opts, llb.AddEnv("GOFLAGS", "-mod=readonly"))
+	} else {
+		opts = append(opts, llb.AddEnv("GOFLAGS", "-mod=mod"))
+	}
+	return opts
+}
+
+// preflightGomodRequires resolves each GomodRequire's @version against the
+// module proxy's protocol endpoints (https://go.dev/ref/mod#goproxy-protocol)
+// so that an unresolvable module/version fails fast during Preprocess,
+// before any LLB is scheduled. Floating versions ("latest", a branch name)
+// are pinned to the concrete version or pseudo-version the proxy reports,
+// and the GomodRequire is updated in place so the effective spec captures
+// what will actually be built.
+func preflightGomodRequires(ctx context.Context, client *http.Client, proxy *GomodProxy, requires []GomodRequire) error {
+	if proxy == nil || proxy.URL == "" || len(requires) == 0 {
+		return nil
+	}
+	if client == nil {
+		client = &http.Client{Timeout: gomodProxyPreflightTimeout}
+	}
+
+	for i := range requires {
+		module, version, ok := strings.Cut(requires[i].Version, "@")
+		if !ok {
+			continue
+		}
+
+		resolved, err := resolveModuleVersion(ctx, client, proxy.URL, module, version)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve %s@%s against proxy %s", module, version, proxy.URL)
+		}
+
+		requires[i].Version = module + "@" + resolved
+	}
+
+	return nil
+}
+
+type moduleInfoResponse struct {
+	Version string `json:"Version"`
+}
+
+// resolveModuleVersion pins a possibly-floating version ("latest", or a
+// branch/ref name) to a concrete version by querying the module proxy.
+// "latest" (or an empty version) is resolved against the `/@v/list`
+// endpoint, picking the newest of the versions it returns; any other
+// version, including a branch or ref name, is resolved via
+// `/@v/<v>.info`, confirming it exists and picking up its canonical form
+// (e.g. a `+incompatible` suffix or pseudo-version).
+func resolveModuleVersion(ctx context.Context, client *http.Client, proxyURL, module, version string) (string, error) {
+	escapedModule, err := escapeModulePath(module)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(proxyURL, "/") + "/" + escapedModule
+
+	if version == "" || version == "latest" {
+		return latestModuleVersion(ctx, client, base)
+	}
+
+	escapedVersion, err := escapeModuleVersion(version)
+	if err != nil {
+		return "", err
+	}
+	endpoint := base + "/@v/" + escapedVersion + ".info"
+
+	body, err := getProxyEndpoint(ctx, client, endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	var info moduleInfoResponse
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", errors.Wrapf(err, "failed to decode module info response from %s", endpoint)
+	}
+	if info.Version == "" {
+		return "", errors.Errorf("proxy response from %s had no Version", endpoint)
+	}
+
+	return info.Version, nil
+}
+
+// latestModuleVersion resolves a floating "latest" version by listing all
+// known versions via `/@v/list` and returning the newest one, per the Go
+// module proxy protocol (https://go.dev/ref/mod#goproxy-protocol).
+func latestModuleVersion(ctx context.Context, client *http.Client, base string) (string, error) {
+	endpoint := base + "/@v/list"
+
+	body, err := getProxyEndpoint(ctx, client, endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	var latest string
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		v := strings.TrimSpace(line)
+		if v == "" {
+			continue
+		}
+		if latest == "" || semver.Compare(v, latest) > 0 {
+			latest = v
+		}
+	}
+	if latest == "" {
+		return "", errors.Errorf("proxy returned no versions from %s", endpoint)
+	}
+
+	return latest, nil
+}
+
+// getProxyEndpoint issues a GET against a module proxy endpoint and returns
+// the response body, failing on any non-200 status.
+func getProxyEndpoint(ctx context.Context, client *http.Client, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("proxy returned %s for %s", resp.Status, endpoint)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// escapeModuleVersion applies the module proxy's "!" escaping to a version
+// string, matching escapeModulePath's handling of uppercase letters in a
+// module path.
+func escapeModuleVersion(v string) (string, error) {
+	return escapeModulePath(v)
+}
+
+// escapeModulePath applies the module proxy's "!" escaping for uppercase
+// letters in a module path, per the goproxy protocol spec.
+func escapeModulePath(m string) (string, error) {
+	var b strings.Builder
+	for _, r := range m {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			b.WriteByte('!')
+			b.WriteRune(r - 'A' + 'a')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
@@ -1,6 +1,7 @@
 package dalec
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"path/filepath"
@@ -15,6 +16,9 @@ import (
 //go:embed scripts/gomod-patch.sh
 var gomodPatchScriptTmpl string
 
+//go:embed scripts/gomod-auth-setup.sh
+var gomodAuthSetupScriptTmpl string
+
 const (
 	// Gomod preprocessing constants
 	gomodPatchSourcePrefix = "__gomod_patch_"
@@ -30,17 +34,21 @@ const (
 //
 // Preprocessing generates LLB states for patches and registers them as context sources
 // that can be retrieved later when sources are fetched.
-func (s *Spec) Preprocess(client gwclient.Client, sOpt SourceOpts, worker llb.State, opts ...llb.ConstraintsOpt) error {
-	if err := s.preprocessGomodEdits(sOpt, worker, opts...); err != nil {
+func (s *Spec) Preprocess(ctx context.Context, client gwclient.Client, sOpt SourceOpts, worker llb.State, opts ...llb.ConstraintsOpt) error {
+	if err := s.preprocessGomodEdits(ctx, client, sOpt, worker, opts...); err != nil {
 		return errors.Wrap(err, "failed to preprocess gomod edits")
 	}
 
+	if err := s.preprocessGoWorkEdits(sOpt, worker, opts...); err != nil {
+		return errors.Wrap(err, "failed to preprocess go.work edits")
+	}
+
 	return nil
 }
 
 // preprocessGomodEdits generates patch LLB states for all gomod replace/require directives
 // and registers them as context sources that can be retrieved later.
-func (s *Spec) preprocessGomodEdits(sOpt SourceOpts, worker llb.State, opts ...llb.ConstraintsOpt) error {
+func (s *Spec) preprocessGomodEdits(ctx context.Context, client gwclient.Client, sOpt SourceOpts, worker llb.State, opts ...llb.ConstraintsOpt) error {
 	gomodSources := s.gomodSources()
 	if len(gomodSources) == 0 {
 		return nil
@@ -69,8 +77,14 @@ func (s *Spec) preprocessGomodEdits(sOpt SourceOpts, worker llb.State, opts ...l
 				continue
 			}
 
+			if gen.Gomod.Edits != nil {
+				if err := preflightGomodRequires(ctx, nil, gen.Gomod.Proxy, gen.Gomod.Edits.Require); err != nil {
+					return errors.Wrapf(err, "gomod proxy preflight failed for source %s", sourceName)
+				}
+			}
+
 			// Generate patch state (LLB state, not solved bytes)
-			patchSt, err := s.generateGomodPatchStateForSource(sourceName, gen, baseState, worker, credHelper, opts...)
+			patchSt, err := s.generateGomodPatchStateForSource(ctx, client, sOpt, sourceName, gen, baseState, worker, credHelper, sOpt.GomodDiffBackend, opts...)
 			if err != nil {
 				return errors.Wrapf(err, "failed to generate gomod patch state for source %s", sourceName)
 			}
@@ -130,11 +144,58 @@ func gomodEditCommand(g *GeneratorGomod) (string, error) {
 		args = append(args, "-require="+arg)
 	}
 
-	if len(args) == 0 {
+	// Process exclude directives
+	for _, x := range g.Edits.Exclude {
+		arg, err := x.goModEditArg()
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "-exclude="+arg)
+	}
+
+	// Process retract directives
+	for _, r := range g.Edits.Retract {
+		arg, err := r.goModEditArg()
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "-retract="+arg)
+	}
+
+	// Process godebug directives
+	for _, d := range g.Edits.GoDebug {
+		arg, err := d.goModEditArg()
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "-godebug="+arg)
+	}
+
+	if g.Edits.Toolchain != "" {
+		args = append(args, "-toolchain="+g.Edits.Toolchain)
+	}
+
+	if g.Edits.Go != "" {
+		args = append(args, "-go="+g.Edits.Go)
+	}
+
+	if len(args) == 0 && !g.Edits.Tidy {
 		return "", nil
 	}
 
-	return "go mod edit " + strings.Join(args, " "), nil
+	var cmd string
+	if len(args) > 0 {
+		cmd = "go mod edit " + strings.Join(args, " ")
+	}
+
+	if g.Edits.Tidy {
+		if cmd != "" {
+			cmd += " && "
+		}
+		cmd += "go mod tidy"
+	}
+
+	return cmd, nil
 }
 
 // moduleInfo holds information about a Go module to be processed
@@ -145,32 +206,49 @@ type moduleInfo struct {
 	GoSumPath     string
 	RelGoModPath  string
 	RelGoSumPath  string
+	// SrcPrefix/DstPrefix are the `git diff --src-prefix`/`--dst-prefix`
+	// values for this module, precomputed so the template doesn't have to
+	// concatenate "a/"+RelModulePath+"/" itself: when RelModulePath is empty
+	// (a root module), that concatenation produces "a//", which git rejects
+	// as a path prefix for `git apply -p1`.
+	SrcPrefix string
+	DstPrefix string
 }
 
 // scriptTemplateData holds data for the gomod patch script template
 type scriptTemplateData struct {
 	PatchPath     string
 	EditCmd       string
-	GitConfig     string
-	GoPrivate     string
-	GoInsecure    string
+	AuthSetup     string
 	GoModFilename string
 	GoSumFilename string
 	Modules       []moduleInfo
 }
 
-// buildGomodPatchScript generates the shell script that applies gomod edits and captures diffs
-func buildGomodPatchScript(editCmd string, paths []string, gen *SourceGenerator, sourceName string, patchOutputDir string) (string, error) {
-	const (
-		workDir = "/work/src"
-	)
-
-	patchPath := filepath.Join(patchOutputDir, gomodPatchFilename)
-	joinedWorkDir := filepath.Join(workDir, sourceName, gen.Subpath)
+// gomodAuthSetup holds the computed auth-related values needed to run
+// `go mod edit`/`go mod tidy` against a gomod generator's configured hosts:
+// git config (insteadOf rewrites, credential helpers), GOPRIVATE/
+// GOINSECURE, known_hosts pinning, and GIT_SSH_COMMAND/SSH_AUTH_SOCK.
+// Shared by the shell and go-git patch backends so both authenticate
+// identically.
+type gomodAuthSetup struct {
+	GitConfig         string
+	GoPrivate         string
+	GoInsecure        string
+	KnownHostsPath    string
+	KnownHostsContent string
+	GitSSHCommand     string
+	SSHAuthSock       string
+}
 
-	// Build git config section
+// buildGomodAuthSetup computes gomodAuthSetup for gen's configured hosts.
+// knownHostsSourcePaths are the resolved, already-mounted paths of any
+// Auth[host].KnownHostsSource contents.
+func buildGomodAuthSetup(gen *SourceGenerator, knownHostsSourcePaths []string) (*gomodAuthSetup, error) {
 	gitConfig := &strings.Builder{}
+	knownHosts := &strings.Builder{}
 	var goPrivate, goInsecure string
+	anyHostSecured := false
 
 	sortedHosts := SortMapKeys(gen.Gomod.Auth)
 	if len(sortedHosts) > 0 {
@@ -186,6 +264,13 @@ func buildGomodPatchScript(editCmd string, paths []string, gen *SourceGenerator,
 					username = sshConfig.Username
 				}
 				fmt.Fprintf(gitConfig, "git config --global url.\"ssh://%[1]s@%[2]s/\".insteadOf https://%[3]s/\n", username, host, gpHost)
+
+				if !sshConfig.Insecure {
+					anyHostSecured = true
+					if auth.KnownHosts != "" {
+						fmt.Fprintln(knownHosts, strings.TrimSpace(auth.KnownHosts))
+					}
+				}
 				continue
 			}
 
@@ -209,38 +294,107 @@ func buildGomodPatchScript(editCmd string, paths []string, gen *SourceGenerator,
 		goInsecure = fmt.Sprintf("%q", joined)
 	}
 
-	// Build module info for each path
-	modules := make([]moduleInfo, 0, len(paths))
-	for _, relPath := range paths {
-		moduleDir := filepath.Clean(filepath.Join(joinedWorkDir, relPath))
-		relModulePath := filepath.Clean(filepath.Join(gen.Subpath, relPath))
-		if relModulePath == "." {
-			relModulePath = ""
+	// A secured host (SSH.Insecure == false, the default) must have pinned
+	// key material, either inline or via KnownHostsSource; GomodAuth.Validate
+	// enforces this too, but check again here since Validate isn't guaranteed
+	// to have run against every caller's gen. Falling back to an insecure
+	// GIT_SSH_COMMAND would silently defeat the host key checking the user
+	// asked for.
+	gitSSHCommand := "ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no"
+	knownHostsPath := ""
+	if anyHostSecured {
+		if knownHosts.Len() == 0 && len(knownHostsSourcePaths) == 0 {
+			return nil, errors.New("gomod auth: a secured SSH host requires KnownHosts or KnownHostsSource; set SSH.Insecure to skip host key verification instead")
 		}
+		knownHostsPath = "/tmp/gomod_known_hosts"
+		gitSSHCommand = fmt.Sprintf("ssh -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", knownHostsPath)
+	}
 
-		relGoModPath := filepath.ToSlash(filepath.Join(relModulePath, gomodFilename))
-		relGoSumPath := filepath.ToSlash(filepath.Join(relModulePath, gosumFilename))
+	sshAuthSock := ""
+	if gen.Gomod.usesSSHAgent() {
+		sshAuthSock = gomodSSHAgentSocketPath
+	}
 
-		goModPath := filepath.Join(moduleDir, gomodFilename)
-		goSumPath := filepath.Join(moduleDir, gosumFilename)
+	return &gomodAuthSetup{
+		GitConfig:         gitConfig.String(),
+		GoPrivate:         goPrivate,
+		GoInsecure:        goInsecure,
+		KnownHostsPath:    knownHostsPath,
+		KnownHostsContent: knownHosts.String(),
+		GitSSHCommand:     gitSSHCommand,
+		SSHAuthSock:       sshAuthSock,
+	}, nil
+}
 
-		modules = append(modules, moduleInfo{
-			RelModulePath: relModulePath,
-			ModuleDir:     moduleDir,
-			GoModPath:     goModPath,
-			GoSumPath:     goSumPath,
-			RelGoModPath:  relGoModPath,
-			RelGoSumPath:  relGoSumPath,
-		})
+// gomodAuthTemplateData holds data for the gomod auth setup script template.
+type gomodAuthTemplateData struct {
+	GitConfig             string
+	GoPrivate             string
+	GoInsecure            string
+	KnownHostsPath        string
+	KnownHostsContent     string
+	KnownHostsSourcePaths []string
+	GitSSHCommand         string
+	SSHAuthSock           string
+}
+
+// renderGomodAuthSetup renders auth as the shell snippet that configures
+// git/go auth (insteadOf rewrites, credential helpers, GOPRIVATE/
+// GOINSECURE, known_hosts, GIT_SSH_COMMAND, SSH_AUTH_SOCK) before any
+// `go mod edit`/`go mod tidy` invocation. Shared by the shell and go-git
+// patch backends.
+func renderGomodAuthSetup(auth *gomodAuthSetup, knownHostsSourcePaths []string) (string, error) {
+	data := gomodAuthTemplateData{
+		GitConfig:             auth.GitConfig,
+		GoPrivate:             auth.GoPrivate,
+		GoInsecure:            auth.GoInsecure,
+		KnownHostsPath:        auth.KnownHostsPath,
+		KnownHostsContent:     auth.KnownHostsContent,
+		KnownHostsSourcePaths: knownHostsSourcePaths,
+		GitSSHCommand:         auth.GitSSHCommand,
+		SSHAuthSock:           auth.SSHAuthSock,
+	}
+
+	tmpl, err := template.New("gomod-auth-setup").Parse(gomodAuthSetupScriptTmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse gomod auth setup script template")
 	}
 
+	script := &strings.Builder{}
+	if err := tmpl.Execute(script, data); err != nil {
+		return "", errors.Wrap(err, "failed to execute gomod auth setup script template")
+	}
+
+	return script.String(), nil
+}
+
+// buildGomodPatchScript generates the shell script that applies gomod edits and captures diffs
+func buildGomodPatchScript(editCmd string, paths []string, gen *SourceGenerator, sourceName string, patchOutputDir string, knownHostsSourcePaths []string) (string, error) {
+	const (
+		workDir = "/work/src"
+	)
+
+	patchPath := filepath.Join(patchOutputDir, gomodPatchFilename)
+	joinedWorkDir := filepath.Join(workDir, sourceName, gen.Subpath)
+
+	auth, err := buildGomodAuthSetup(gen, knownHostsSourcePaths)
+	if err != nil {
+		return "", err
+	}
+
+	authSetup, err := renderGomodAuthSetup(auth, knownHostsSourcePaths)
+	if err != nil {
+		return "", err
+	}
+
+	// Build module info for each path
+	modules := computeModuleInfos(joinedWorkDir, gen.Subpath, paths)
+
 	// Prepare template data
 	data := scriptTemplateData{
 		PatchPath:     patchPath,
 		EditCmd:       editCmd,
-		GitConfig:     gitConfig.String(),
-		GoPrivate:     goPrivate,
-		GoInsecure:    goInsecure,
+		AuthSetup:     authSetup,
 		GoModFilename: gomodFilename,
 		GoSumFilename: gosumFilename,
 		Modules:       modules,
@@ -260,10 +414,49 @@ func buildGomodPatchScript(editCmd string, paths []string, gen *SourceGenerator,
 	return script.String(), nil
 }
 
+// computeModuleInfos resolves the on-disk layout for each module path a
+// gomod generator should edit, relative to both the container work dir and
+// the source root. Shared by the shell and go-git patch backends so both
+// agree on where go.mod/go.sum live.
+func computeModuleInfos(joinedWorkDir, subpath string, paths []string) []moduleInfo {
+	modules := make([]moduleInfo, 0, len(paths))
+	for _, relPath := range paths {
+		moduleDir := filepath.Clean(filepath.Join(joinedWorkDir, relPath))
+		relModulePath := filepath.Clean(filepath.Join(subpath, relPath))
+		if relModulePath == "." {
+			relModulePath = ""
+		}
+
+		relGoModPath := filepath.ToSlash(filepath.Join(relModulePath, gomodFilename))
+		relGoSumPath := filepath.ToSlash(filepath.Join(relModulePath, gosumFilename))
+
+		goModPath := filepath.Join(moduleDir, gomodFilename)
+		goSumPath := filepath.Join(moduleDir, gosumFilename)
+
+		srcPrefix, dstPrefix := "a/", "b/"
+		if relModulePath != "" {
+			srcPrefix = "a/" + relModulePath + "/"
+			dstPrefix = "b/" + relModulePath + "/"
+		}
+
+		modules = append(modules, moduleInfo{
+			RelModulePath: relModulePath,
+			ModuleDir:     moduleDir,
+			GoModPath:     goModPath,
+			GoSumPath:     goSumPath,
+			RelGoModPath:  relGoModPath,
+			RelGoSumPath:  relGoSumPath,
+			SrcPrefix:     srcPrefix,
+			DstPrefix:     dstPrefix,
+		})
+	}
+	return modules
+}
+
 // generateGomodPatchStateForSource generates a single merged patch LLB state for all paths
 // in a gomod generator by running go mod edit + tidy and capturing the diff.
 // Returns the LLB state containing the patch file, or nil if no changes are needed.
-func (s *Spec) generateGomodPatchStateForSource(sourceName string, gen *SourceGenerator, baseState llb.State, worker llb.State, credHelper llb.RunOption, opts ...llb.ConstraintsOpt) (*llb.State, error) {
+func (s *Spec) generateGomodPatchStateForSource(ctx context.Context, client gwclient.Client, sOpt SourceOpts, sourceName string, gen *SourceGenerator, baseState llb.State, worker llb.State, credHelper llb.RunOption, backend GomodDiffBackend, opts ...llb.ConstraintsOpt) (*llb.State, error) {
 	editCmd, err := gomodEditCommand(gen.Gomod)
 	if err != nil {
 		return nil, err
@@ -278,6 +471,10 @@ func (s *Spec) generateGomodPatchStateForSource(sourceName string, gen *SourceGe
 		paths = []string{"."}
 	}
 
+	if backend == GomodDiffBackendGoGit {
+		return s.generateGomodPatchStateGoGit(ctx, client, sOpt, sourceName, gen, editCmd, paths, baseState, worker, credHelper, opts...)
+	}
+
 	const (
 		workDir   = "/work/src"
 		proxyPath = "/go/pkg/mod" // Standard Go module cache path
@@ -286,8 +483,28 @@ func (s *Spec) generateGomodPatchStateForSource(sourceName string, gen *SourceGe
 	// Create a temporary directory for patch generation
 	patchOutputDir := "/tmp/patch-work"
 
+	// Resolve any KnownHostsSource references to mounts; their contents are
+	// cat'd into the combined known_hosts file by the script.
+	var knownHostsSourceMounts []llb.RunOption
+	var knownHostsSourcePaths []string
+	for _, host := range SortMapKeys(gen.Gomod.Auth) {
+		auth := gen.Gomod.Auth[host]
+		if auth.KnownHostsSource == "" {
+			continue
+		}
+		srcState, ok := s.getPatchedSources(sOpt, worker, func(name string) bool {
+			return name == auth.KnownHostsSource
+		}, opts...)[auth.KnownHostsSource]
+		if !ok {
+			continue
+		}
+		mountDir := "/tmp/gomod_known_hosts_src/" + host
+		knownHostsSourceMounts = append(knownHostsSourceMounts, llb.AddMount(mountDir, srcState))
+		knownHostsSourcePaths = append(knownHostsSourcePaths, filepath.Join(mountDir, "known_hosts"))
+	}
+
 	// Generate the shell script
-	scriptContent, err := buildGomodPatchScript(editCmd, paths, gen, sourceName, patchOutputDir)
+	scriptContent, err := buildGomodPatchScript(editCmd, paths, gen, sourceName, patchOutputDir, knownHostsSourcePaths)
 	if err != nil {
 		return nil, err
 	}
@@ -301,25 +518,34 @@ func (s *Spec) generateGomodPatchStateForSource(sourceName string, gen *SourceGe
 	// Create a scratch state to capture the patch output
 	patchOutput := llb.Scratch()
 
+	moduleCacheMount := llb.AddMount(proxyPath, llb.Scratch(), llb.AsPersistentCacheDir(GomodCacheKey, llb.CacheMountShared))
+	if proxy := gen.Gomod.Proxy; proxy != nil && proxy.Offline && proxy.OfflineCache != "" {
+		if cacheState, ok := s.getPatchedSources(sOpt, worker, func(name string) bool {
+			return name == proxy.OfflineCache
+		}, opts...)[proxy.OfflineCache]; ok {
+			moduleCacheMount = llb.AddMount(proxyPath, cacheState)
+		}
+	}
+
 	runOpts := []llb.RunOption{
 		llb.Args([]string{"/gomod-patch.sh"}),
 		llb.AddMount("/gomod-patch.sh", scriptState, llb.SourcePath("/gomod-patch.sh")),
 		llb.AddMount(workDir, baseState),
-		llb.AddMount(proxyPath, llb.Scratch(), llb.AsPersistentCacheDir(GomodCacheKey, llb.CacheMountShared)),
+		moduleCacheMount,
 		llb.AddMount(patchOutputDir, patchOutput), // Mount scratch state to capture patch file
 		llb.AddEnv("GOPATH", "/go"),
 		llb.AddEnv("TMP_GOMODCACHE", proxyPath),
-		llb.AddEnv("GIT_SSH_COMMAND", "ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no"),
 		WithConstraints(opts...),
 		ProgressGroup("Generate gomod patch for source: " + sourceName),
 	}
 
+	runOpts = append(runOpts, knownHostsSourceMounts...)
+	runOpts = append(runOpts, gen.Gomod.Proxy.runOpts()...)
+	runOpts = append(runOpts, gen.withGomodSecretsAndSockets()...)
+
 	if credHelper != nil {
 		runOpts = append(runOpts, credHelper)
 	}
-	if secretOpt := gen.withGomodSecretsAndSockets(); secretOpt != nil {
-		runOpts = append(runOpts, secretOpt)
-	}
 
 	// Generate the LLB state that captures the patch output mount
 	// The AddMount call returns the state of the patchOutput scratch.
@@ -0,0 +1,215 @@
+package dalec
+
+import (
+	_ "embed"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+//go:embed scripts/gowork-patch.sh
+var goWorkPatchScriptTmpl string
+
+const (
+	// GoWork preprocessing constants
+	goWorkPatchSourcePrefix = "__gowork_patch_"
+	goWorkPatchFilename     = "gowork.patch"
+)
+
+// goWorkSources returns the subset of s.Sources that declare a GeneratorGoWork.
+func (s *Spec) goWorkSources() map[string]Source {
+	out := make(map[string]Source)
+	for name, src := range s.Sources {
+		for _, gen := range src.Generate {
+			if gen != nil && gen.GoWork != nil {
+				out[name] = src
+				break
+			}
+		}
+	}
+	return out
+}
+
+// preprocessGoWorkEdits generates patch LLB states for all go.work
+// use/replace directives and registers them as context sources that can be
+// retrieved later, mirroring preprocessGomodEdits but operating on a
+// workspace (go.work) rather than a single module.
+func (s *Spec) preprocessGoWorkEdits(sOpt SourceOpts, worker llb.State, opts ...llb.ConstraintsOpt) error {
+	goWorkSources := s.goWorkSources()
+	if len(goWorkSources) == 0 {
+		return nil
+	}
+
+	baseSources := s.getPatchedSources(sOpt, worker, func(name string) bool {
+		_, ok := goWorkSources[name]
+		return ok
+	}, opts...)
+
+	for sourceName, src := range goWorkSources {
+		if err := validateGeneratorWorkspace(src.Generate); err != nil {
+			return errors.Wrapf(err, "source %s", sourceName)
+		}
+
+		baseState, ok := baseSources[sourceName]
+		if !ok {
+			continue
+		}
+
+		for _, gen := range src.Generate {
+			if gen == nil || gen.GoWork == nil {
+				continue
+			}
+
+			patchSt, err := s.generateGoWorkPatchStateForSource(sourceName, gen, baseState, worker, opts...)
+			if err != nil {
+				return errors.Wrapf(err, "failed to generate go.work patch state for source %s", sourceName)
+			}
+
+			if patchSt == nil {
+				continue
+			}
+
+			patchSourceName := fmt.Sprintf(goWorkPatchSourcePrefix+"%s", sourceName)
+			s.Sources[patchSourceName] = Source{
+				LLB: newSourceLLB(*patchSt),
+			}
+
+			if s.Patches == nil {
+				s.Patches = make(map[string][]PatchSpec)
+			}
+
+			strip := 1
+			s.Patches[sourceName] = append(s.Patches[sourceName], PatchSpec{
+				Source: patchSourceName,
+				Path:   goWorkPatchFilename,
+				Strip:  &strip,
+			})
+		}
+	}
+
+	return nil
+}
+
+// goWorkScriptData holds data for the go.work patch script template.
+type goWorkScriptData struct {
+	WorkspaceDir string
+	// RelWorkspacePath is gen.Subpath, relative to the source root, used to
+	// prefix the diff's file paths so the patch still applies correctly
+	// (with PatchSpec.Strip == 1) when the workspace isn't at the source
+	// root.
+	RelWorkspacePath string
+	// SrcPrefix/DstPrefix are the `git diff --src-prefix`/`--dst-prefix`
+	// values, precomputed so the template doesn't concatenate
+	// "a/"+RelWorkspacePath+"/" itself: when RelWorkspacePath is empty (the
+	// workspace is at the source root), that concatenation produces "a//",
+	// which git rejects as a path prefix for `git apply -p1`.
+	SrcPrefix string
+	DstPrefix string
+	Use       []string
+	EditArgs  string
+	PatchPath string
+}
+
+// buildGoWorkPatchScript generates the shell script that creates/updates
+// go.work and captures the resulting diff.
+func buildGoWorkPatchScript(sourceName string, gen *SourceGenerator, editArgs []string, patchOutputDir string) (string, error) {
+	const workDir = "/work/src"
+
+	relWorkspacePath := filepath.Clean(gen.Subpath)
+	if relWorkspacePath == "." {
+		relWorkspacePath = ""
+	}
+
+	srcPrefix, dstPrefix := "a/", "b/"
+	if relWorkspacePath != "" {
+		srcPrefix = "a/" + relWorkspacePath + "/"
+		dstPrefix = "b/" + relWorkspacePath + "/"
+	}
+
+	// GoWork.Use is relative to the source root, but the script runs
+	// `go work use` from the workspace dir (gen.Subpath).
+	use := make([]string, 0, len(gen.GoWork.Use))
+	for _, u := range gen.GoWork.Use {
+		rel, err := filepath.Rel(filepath.Clean(gen.Subpath), filepath.Clean(u))
+		if err != nil {
+			return "", errors.Wrapf(err, "go.work use entry %q", u)
+		}
+		use = append(use, rel)
+	}
+
+	data := goWorkScriptData{
+		WorkspaceDir:     filepath.Join(workDir, sourceName, gen.Subpath),
+		RelWorkspacePath: relWorkspacePath,
+		SrcPrefix:        srcPrefix,
+		DstPrefix:        dstPrefix,
+		Use:              use,
+		EditArgs:         strings.Join(editArgs, " "),
+		PatchPath:        filepath.Join(patchOutputDir, goWorkPatchFilename),
+	}
+
+	tmpl, err := template.New("gowork-patch").Parse(goWorkPatchScriptTmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse go.work patch script template")
+	}
+
+	script := &strings.Builder{}
+	if err := tmpl.Execute(script, data); err != nil {
+		return "", errors.Wrap(err, "failed to execute go.work patch script template")
+	}
+
+	return script.String(), nil
+}
+
+// generateGoWorkPatchStateForSource generates a patch LLB state that
+// captures go.work/go.work.sum changes from running `go work use`/`go work
+// edit` across the workspace. Always returns the LLB state containing the
+// patch file; the patch file is empty when the workspace needs no changes.
+func (s *Spec) generateGoWorkPatchStateForSource(sourceName string, gen *SourceGenerator, baseState llb.State, worker llb.State, opts ...llb.ConstraintsOpt) (*llb.State, error) {
+	editArgs, err := gen.GoWork.goWorkEditArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		workDir = "/work/src"
+	)
+
+	patchOutputDir := "/tmp/gowork-patch-work"
+
+	scriptContent, err := buildGoWorkPatchScript(sourceName, gen, editArgs, patchOutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptState := llb.Scratch().File(
+		llb.Mkfile("/gowork-patch.sh", 0755, []byte(scriptContent)),
+		WithConstraints(opts...),
+	)
+
+	patchOutput := llb.Scratch()
+
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/gowork-patch.sh"}),
+		llb.AddMount("/gowork-patch.sh", scriptState, llb.SourcePath("/gowork-patch.sh")),
+		llb.AddMount(workDir, baseState),
+		llb.AddMount(patchOutputDir, patchOutput),
+		llb.AddEnv("GOPATH", "/go"),
+		WithConstraints(opts...),
+		ProgressGroup("Generate go.work patch for source: " + sourceName),
+	}
+
+	patchMount := worker.Run(runOpts...).AddMount(patchOutputDir, patchOutput)
+
+	patchSourceName := fmt.Sprintf(goWorkPatchSourcePrefix+"%s", sourceName)
+	finalPatchPath := filepath.Join("/", patchSourceName, goWorkPatchFilename)
+
+	patchSt := llb.Scratch().
+		File(llb.Mkdir(filepath.Join("/", patchSourceName), 0755, llb.WithParents(true)), WithConstraints(opts...)).
+		File(llb.Copy(patchMount, "/"+goWorkPatchFilename, finalPatchPath), WithConstraints(opts...))
+
+	return &patchSt, nil
+}
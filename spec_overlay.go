@@ -0,0 +1,244 @@
+package dalec
+
+import (
+	"os"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/pkg/errors"
+)
+
+// patchDirectiveKey marks a sequence element as carrying merge instructions
+// rather than being a plain value, e.g.:
+//
+//	gomod:
+//	  edits:
+//	    replace:
+//	      - $patch: merge
+//	        old: github.com/foo/bar
+//	        new: github.com/foo/bar@v1.2.3
+const patchDirectiveKey = "$patch"
+
+// sequenceIdentityKeys lists the mapping keys, in preference order, used to
+// identify "the same" sequence element across base and overlay documents so
+// that a `$patch` entry can target it without restating the whole list.
+var sequenceIdentityKeys = []string{"name", "module", "old"}
+
+// LoadSpecWithOverlays loads the spec at path and deep-merges each of the
+// supplied overlay documents over it, in order, before parsing the result
+// into a Spec. This lets an operator keep a "foo.yml.local" (and/or
+// "foo.yml.d/*.yml" drop-ins) alongside a checked-in "foo.yml" to override
+// sources, patches, and gomod edits per-environment without editing the
+// base file. Missing overlay paths are skipped rather than treated as
+// errors, so callers can pass optional overlay locations unconditionally.
+func LoadSpecWithOverlays(path string, overlays ...string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	base, err := parser.ParseBytes(data, parser.ParseComments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	if len(base.Docs) == 0 || base.Docs[0].Body == nil {
+		return nil, errors.Errorf("%s contains no YAML document", path)
+	}
+
+	merged := base.Docs[0].Body
+
+	for _, overlayPath := range overlays {
+		overlayData, err := os.ReadFile(overlayPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to read overlay %s", overlayPath)
+		}
+
+		overlay, err := parser.ParseBytes(overlayData, parser.ParseComments)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse overlay %s", overlayPath)
+		}
+		if len(overlay.Docs) == 0 || overlay.Docs[0].Body == nil {
+			continue
+		}
+
+		merged, err = mergeYAMLNodes(merged, overlay.Docs[0].Body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to merge overlay %s", overlayPath)
+		}
+	}
+
+	var spec Spec
+	if err := yaml.NodeToValue(merged, &spec); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode merged spec for %s", path)
+	}
+
+	return &spec, nil
+}
+
+// mergeYAMLNodes deep-merges overlay onto base: mappings are merged key by
+// key, sequences are merged per mergeSequenceNodes, and anything else
+// (scalars, or a shape mismatch such as a map replacing a scalar) is
+// replaced outright by the overlay value.
+func mergeYAMLNodes(base, overlay ast.Node) (ast.Node, error) {
+	if baseMap, ok := base.(*ast.MappingNode); ok {
+		if overlayMap, ok := overlay.(*ast.MappingNode); ok {
+			return mergeMappingNodes(baseMap, overlayMap)
+		}
+		return overlay, nil
+	}
+
+	if baseSeq, ok := base.(*ast.SequenceNode); ok {
+		if overlaySeq, ok := overlay.(*ast.SequenceNode); ok {
+			return mergeSequenceNodes(baseSeq, overlaySeq)
+		}
+		return overlay, nil
+	}
+
+	return overlay, nil
+}
+
+func mergeMappingNodes(base, overlay *ast.MappingNode) (*ast.MappingNode, error) {
+	for _, ov := range overlay.Values {
+		key := ov.Key.String()
+
+		var matched *ast.MappingValueNode
+		for _, bv := range base.Values {
+			if bv.Key.String() == key {
+				matched = bv
+				break
+			}
+		}
+
+		if matched == nil {
+			base.Values = append(base.Values, ov)
+			continue
+		}
+
+		mergedVal, err := mergeYAMLNodes(matched.Value, ov.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %q", key)
+		}
+		matched.Value = mergedVal
+	}
+
+	return base, nil
+}
+
+// mergeSequenceNodes appends overlay items to base, except when an overlay
+// item is a mapping carrying a `$patch: merge|replace|delete` directive: in
+// that case it is matched against an existing base item by identity field
+// (see sequenceIdentityKeys) and merged into, replaces, or removes it
+// in-place instead of being appended. A merge or replace item with no
+// matching base item is appended; a delete item with no match is dropped.
+func mergeSequenceNodes(base, overlay *ast.SequenceNode) (*ast.SequenceNode, error) {
+	for _, item := range overlay.Values {
+		mode, idKey, idVal, ok := sequencePatchDirective(item)
+		if !ok {
+			base.Values = append(base.Values, item)
+			continue
+		}
+
+		idx := findSequenceItemByIdentity(base.Values, idKey, idVal)
+		clean := stripPatchDirective(item)
+
+		switch mode {
+		case "delete":
+			if idx >= 0 {
+				base.Values = append(base.Values[:idx], base.Values[idx+1:]...)
+			}
+		case "replace":
+			if idx >= 0 {
+				base.Values[idx] = clean
+			} else {
+				base.Values = append(base.Values, clean)
+			}
+		default: // "merge" (the default when $patch is present but unset/unrecognized)
+			if idx >= 0 {
+				if existing, ok := base.Values[idx].(*ast.MappingNode); ok {
+					mergedItem, err := mergeMappingNodes(existing, clean.(*ast.MappingNode))
+					if err != nil {
+						return nil, err
+					}
+					base.Values[idx] = mergedItem
+					continue
+				}
+			}
+			base.Values = append(base.Values, clean)
+		}
+	}
+
+	return base, nil
+}
+
+// sequencePatchDirective reports whether item is a mapping carrying a
+// $patch directive, and if so returns the patch mode along with the
+// identity field/value used to locate the matching base element.
+func sequencePatchDirective(item ast.Node) (mode, idKey, idVal string, ok bool) {
+	m, isMap := item.(*ast.MappingNode)
+	if !isMap {
+		return "", "", "", false
+	}
+
+	hasPatch := false
+	for _, v := range m.Values {
+		if v.Key.String() != patchDirectiveKey {
+			continue
+		}
+		hasPatch = true
+		mode = v.Value.String()
+	}
+	if !hasPatch {
+		return "", "", "", false
+	}
+
+	for _, key := range sequenceIdentityKeys {
+		for _, v := range m.Values {
+			if v.Key.String() == key {
+				return mode, key, v.Value.String(), true
+			}
+		}
+	}
+
+	return mode, "", "", true
+}
+
+// stripPatchDirective removes the $patch key from item's mapping values in
+// place and returns item, so the key doesn't leak into the decoded Spec.
+func stripPatchDirective(item ast.Node) ast.Node {
+	m, ok := item.(*ast.MappingNode)
+	if !ok {
+		return item
+	}
+
+	values := make([]*ast.MappingValueNode, 0, len(m.Values))
+	for _, v := range m.Values {
+		if v.Key.String() == patchDirectiveKey {
+			continue
+		}
+		values = append(values, v)
+	}
+	m.Values = values
+	return m
+}
+
+func findSequenceItemByIdentity(items []ast.Node, idKey, idVal string) int {
+	if idKey == "" {
+		return -1
+	}
+	for i, item := range items {
+		m, ok := item.(*ast.MappingNode)
+		if !ok {
+			continue
+		}
+		for _, v := range m.Values {
+			if v.Key.String() == idKey && v.Value.String() == idVal {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,70 @@
+package dalec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestLoadSpecWithOverlaysMergesScalarsAndSequences(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	base := `
+name: myspec
+version: 1.0.0
+sources:
+  app:
+    generate:
+      - gomod:
+          edits:
+            replace:
+              - old: github.com/foo/bar
+                new: github.com/foo/bar@v1.0.0
+`
+	local := `
+version: 1.0.1
+sources:
+  app:
+    generate:
+      - gomod:
+          edits:
+            replace:
+              - $patch: merge
+                old: github.com/foo/bar
+                new: github.com/foo/bar@v1.2.3
+`
+
+	basePath := filepath.Join(dir, "foo.yml")
+	localPath := filepath.Join(dir, "foo.yml.local")
+	assert.NilError(t, os.WriteFile(basePath, []byte(base), 0o644))
+	assert.NilError(t, os.WriteFile(localPath, []byte(local), 0o644))
+
+	spec, err := LoadSpecWithOverlays(basePath, localPath)
+	assert.NilError(t, err)
+
+	assert.Check(t, cmp.Equal(spec.Version, "1.0.1"))
+
+	src, ok := spec.Sources["app"]
+	assert.Check(t, ok)
+	assert.Check(t, cmp.Len(src.Generate, 1))
+	replace := src.Generate[0].Gomod.Edits.Replace
+	assert.Check(t, cmp.Len(replace, 1))
+	assert.Check(t, cmp.Equal(replace[0].Update, "github.com/foo/bar@v1.2.3"))
+}
+
+func TestLoadSpecWithOverlaysSkipsMissingOverlay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "foo.yml")
+	assert.NilError(t, os.WriteFile(basePath, []byte("name: myspec\nversion: 1.0.0\n"), 0o644))
+
+	spec, err := LoadSpecWithOverlays(basePath, filepath.Join(dir, "foo.yml.local"))
+	assert.NilError(t, err)
+	assert.Check(t, cmp.Equal(spec.Version, "1.0.0"))
+}